@@ -0,0 +1,55 @@
+// Command pkcs11signer is a standalone remote signer process for validators
+// that keep their consensus key inside a PKCS#11 HSM (e.g. SoftHSM, YubiHSM,
+// AWS CloudHSM) instead of on disk. It listens on a unix socket and serves
+// sign requests using privval.PKCS11Signer; point a validator's IPCVal at
+// the same socket to delegate signing to the HSM.
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/tendermint/tendermint/libs/log"
+	"github.com/tendermint/tendermint/privval"
+)
+
+func main() {
+	var (
+		socketAddr = flag.String("addr", "", "unix socket to listen on, e.g. /tmp/pkcs11signer.sock")
+		modulePath = flag.String("module", "", "path to the PKCS#11 module (.so) to load")
+		keyLabel   = flag.String("key-label", "", "label of the ed25519 key to sign with")
+		slot       = flag.Uint("slot", 0, "PKCS#11 token slot")
+		pin        = flag.String("pin", os.Getenv("PKCS11_PIN"), "PKCS#11 user PIN (defaults to $PKCS11_PIN)")
+	)
+	flag.Parse()
+
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+
+	if *socketAddr == "" || *modulePath == "" || *keyLabel == "" {
+		logger.Error("pkcs11signer: -addr, -module and -key-label are required")
+		os.Exit(1)
+	}
+
+	signer := privval.NewPKCS11Signer(
+		logger,
+		*modulePath,
+		*keyLabel,
+		privval.PKCS11SignerSlot(*slot),
+		privval.PKCS11SignerPIN(*pin),
+	)
+
+	closeCh := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		close(closeCh)
+	}()
+
+	if err := privval.ServePKCS11Signer(logger, *socketAddr, signer, closeCh); err != nil {
+		logger.Error("pkcs11signer: exited", "err", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,175 @@
+package privval
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	cmn "github.com/tendermint/tendermint/libs/common"
+)
+
+// ErrDoubleSignAttempt is returned by IPCVal when asked to sign a vote or
+// proposal whose (height, round, step) does not strictly advance past the
+// last one it forwarded to the remote signer. Any occurrence of this error
+// means either the remote signer or its caller attempted to sign something
+// that could equivocate, and must be treated as fatal by consensus.
+var ErrDoubleSignAttempt = errors.New("privval: attempt to sign a vote or proposal that would be a double sign")
+
+// step mirrors the step values used in votes and proposals: propose < prevote
+// < precommit, with signing a proposal sharing the propose step.
+type step int8
+
+const (
+	stepNone      step = 0 // Used to distinguish the initial state
+	stepPropose   step = 1
+	stepPrevote   step = 2
+	stepPrecommit step = 3
+)
+
+// HighWaterMark is a crash-safe record of the highest (height, round, step)
+// IPCVal has forwarded to its remote signer, together with the sign bytes
+// and signature produced for it. IPCVal consults it before every
+// SignVoteRequest/SignProposalRequest and refuses to forward anything that
+// is not strictly greater, protecting against a misbehaving or misconfigured
+// remote signer double signing.
+type HighWaterMark struct {
+	Height    int64        `json:"height"`
+	Round     int          `json:"round"`
+	Step      step         `json:"step"`
+	Signature []byte       `json:"signature,omitempty"`
+	SignBytes cmn.HexBytes `json:"signbytes,omitempty"`
+
+	mtx      sync.Mutex
+	filePath string
+	syncSave bool
+}
+
+// HighWaterMarkOption sets an optional parameter on a HighWaterMark.
+type HighWaterMarkOption func(*HighWaterMark)
+
+// HighWaterMarkSync sets whether Advance fsyncs the HWM file after every
+// write. Defaults to true; disabling it trades a (small) double-sign window
+// after an unclean shutdown for lower latency on the signing hot path.
+func HighWaterMarkSync(sync bool) HighWaterMarkOption {
+	return func(hwm *HighWaterMark) { hwm.syncSave = sync }
+}
+
+// NewHighWaterMark returns a HighWaterMark backed by filePath. If filePath
+// already exists it is loaded; otherwise a zero-valued HWM is returned and
+// the file is created on the first Advance.
+func NewHighWaterMark(filePath string, options ...HighWaterMarkOption) (*HighWaterMark, error) {
+	hwm := &HighWaterMark{filePath: filePath, syncSave: true}
+
+	if cmn.FileExists(filePath) {
+		if err := hwm.load(); err != nil {
+			return nil, fmt.Errorf("privval: loading high-water-mark from %q: %w", filePath, err)
+		}
+	}
+
+	for _, optionFunc := range options {
+		optionFunc(hwm)
+	}
+
+	return hwm, nil
+}
+
+// NewHighWaterMarkFromFilePVState bootstraps a HighWaterMark at filePath
+// from an existing priv_validator_state.json, so that operators migrating
+// from a bare FilePV to an IPCVal-fronted remote signer start out with the
+// same double-sign protection they already had on disk.
+func NewHighWaterMarkFromFilePVState(statePath, filePath string) (*HighWaterMark, error) {
+	b, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		return nil, fmt.Errorf("privval: reading priv_validator_state %q: %w", statePath, err)
+	}
+
+	hwm := &HighWaterMark{filePath: filePath, syncSave: true}
+	if err := json.Unmarshal(b, hwm); err != nil {
+		return nil, fmt.Errorf("privval: parsing priv_validator_state %q: %w", statePath, err)
+	}
+
+	if err := hwm.save(); err != nil {
+		return nil, err
+	}
+
+	return hwm, nil
+}
+
+func (hwm *HighWaterMark) load() error {
+	b, err := ioutil.ReadFile(hwm.filePath)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, hwm)
+}
+
+// CheckHRS returns an error if (height, round, step) does not represent
+// forward progress from the last recorded high-water-mark. When the triple
+// is unchanged and signBytes matches what was last signed, it returns the
+// previously produced signature so the caller can resend it instead of
+// asking the remote signer to sign again.
+func (hwm *HighWaterMark) CheckHRS(height int64, round int, st step, signBytes []byte) ([]byte, error) {
+	hwm.mtx.Lock()
+	defer hwm.mtx.Unlock()
+
+	if hwm.Height > height {
+		return nil, ErrDoubleSignAttempt
+	}
+	if hwm.Height == height {
+		if hwm.Round > round {
+			return nil, ErrDoubleSignAttempt
+		}
+		if hwm.Round == round {
+			if hwm.Step > st {
+				return nil, ErrDoubleSignAttempt
+			}
+			if hwm.Step == st {
+				if hwm.SignBytes != nil && cmn.HexBytes(signBytes).String() == hwm.SignBytes.String() {
+					return hwm.Signature, nil
+				}
+				return nil, ErrDoubleSignAttempt
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// Advance records (height, round, step, signBytes, signature) as the new
+// high-water-mark and persists it to disk.
+func (hwm *HighWaterMark) Advance(height int64, round int, st step, signBytes, signature []byte) error {
+	hwm.mtx.Lock()
+	hwm.Height = height
+	hwm.Round = round
+	hwm.Step = st
+	hwm.SignBytes = signBytes
+	hwm.Signature = signature
+	hwm.mtx.Unlock()
+
+	return hwm.save()
+}
+
+// save atomically (write-to-tempfile + rename, optionally fsync'd) persists
+// the high-water-mark so a crash mid-write can never leave a corrupt or
+// stale file behind.
+func (hwm *HighWaterMark) save() error {
+	hwm.mtx.Lock()
+	b, err := json.Marshal(hwm)
+	hwm.mtx.Unlock()
+	if err != nil {
+		return fmt.Errorf("privval: marshaling high-water-mark: %w", err)
+	}
+
+	if hwm.syncSave {
+		return cmn.WriteFileAtomic(hwm.filePath, b, 0600)
+	}
+
+	tmpPath := hwm.filePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, hwm.filePath)
+}
@@ -0,0 +1,107 @@
+package privval
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHighWaterMark(t *testing.T) (*HighWaterMark, string) {
+	dir, err := ioutil.TempDir("", "hwm_test")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "signstate.json")
+	hwm, err := NewHighWaterMark(path)
+	require.NoError(t, err)
+
+	return hwm, path
+}
+
+func TestHighWaterMarkCheckHRSAllowsForwardProgress(t *testing.T) {
+	hwm, _ := newTestHighWaterMark(t)
+
+	sig, err := hwm.CheckHRS(1, 0, stepPrevote, []byte("vote-1"))
+	require.NoError(t, err)
+	require.Nil(t, sig)
+
+	require.NoError(t, hwm.Advance(1, 0, stepPrevote, []byte("vote-1"), []byte("sig-1")))
+
+	// Higher step, same height/round.
+	_, err = hwm.CheckHRS(1, 0, stepPrecommit, []byte("vote-2"))
+	require.NoError(t, err)
+
+	require.NoError(t, hwm.Advance(1, 0, stepPrecommit, []byte("vote-2"), []byte("sig-2")))
+
+	// Higher round.
+	_, err = hwm.CheckHRS(1, 1, stepPropose, []byte("vote-3"))
+	require.NoError(t, err)
+
+	require.NoError(t, hwm.Advance(1, 1, stepPropose, []byte("vote-3"), []byte("sig-3")))
+
+	// Higher height.
+	_, err = hwm.CheckHRS(2, 0, stepPropose, []byte("vote-4"))
+	require.NoError(t, err)
+}
+
+func TestHighWaterMarkCheckHRSRejectsRegression(t *testing.T) {
+	hwm, _ := newTestHighWaterMark(t)
+	require.NoError(t, hwm.Advance(10, 2, stepPrecommit, []byte("vote"), []byte("sig")))
+
+	cases := []struct {
+		name   string
+		height int64
+		round  int
+		step   step
+	}{
+		{"lower height", 9, 2, stepPrecommit},
+		{"same height, lower round", 10, 1, stepPrecommit},
+		{"same height and round, lower step", 10, 2, stepPrevote},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			sig, err := hwm.CheckHRS(tc.height, tc.round, tc.step, []byte("other-vote"))
+			require.Equal(t, ErrDoubleSignAttempt, err)
+			require.Nil(t, sig)
+		})
+	}
+}
+
+func TestHighWaterMarkCheckHRSSameHRS(t *testing.T) {
+	hwm, _ := newTestHighWaterMark(t)
+	signBytes := []byte("vote-bytes")
+	signature := []byte("vote-signature")
+	require.NoError(t, hwm.Advance(10, 2, stepPrecommit, signBytes, signature))
+
+	// Identical (height, round, step, signBytes): return the cached signature
+	// rather than asking the remote signer to sign again.
+	sig, err := hwm.CheckHRS(10, 2, stepPrecommit, signBytes)
+	require.NoError(t, err)
+	require.Equal(t, signature, sig)
+
+	// Identical (height, round, step) but different signBytes: this is
+	// exactly the double sign CheckHRS exists to catch.
+	sig, err = hwm.CheckHRS(10, 2, stepPrecommit, []byte("different-vote-bytes"))
+	require.Equal(t, ErrDoubleSignAttempt, err)
+	require.Nil(t, sig)
+}
+
+func TestHighWaterMarkAdvancePersistsAcrossLoads(t *testing.T) {
+	hwm, path := newTestHighWaterMark(t)
+	require.NoError(t, hwm.Advance(5, 1, stepPropose, []byte("vote"), []byte("sig")))
+
+	reloaded, err := NewHighWaterMark(path)
+	require.NoError(t, err)
+	require.Equal(t, hwm.Height, reloaded.Height)
+	require.Equal(t, hwm.Round, reloaded.Round)
+	require.Equal(t, hwm.Step, reloaded.Step)
+	require.Equal(t, hwm.Signature, reloaded.Signature)
+
+	// The reloaded HWM must reject what the original would have rejected.
+	_, err = reloaded.CheckHRS(4, 0, stepPropose, []byte("other"))
+	require.Equal(t, ErrDoubleSignAttempt, err)
+}
@@ -1,10 +1,13 @@
 package privval
 
 import (
+	"fmt"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/tendermint/tendermint/crypto"
 	cmn "github.com/tendermint/tendermint/libs/common"
 	"github.com/tendermint/tendermint/libs/log"
 	"github.com/tendermint/tendermint/types"
@@ -25,6 +28,43 @@ func IPCValHeartbeat(period time.Duration) IPCValOption {
 	return func(sc *IPCVal) { sc.connHeartbeat = period }
 }
 
+// IPCValStateFile sets the path IPCVal persists its signing high-water-mark
+// to. When set, IPCVal refuses to forward any SignVoteRequest or
+// SignProposalRequest that does not strictly advance past the last (height,
+// round, step) it saw, protecting against a misbehaving remote signer
+// double signing. Unset by default, which preserves the old behavior of
+// trusting the remote signer entirely.
+func IPCValStateFile(path string) IPCValOption {
+	return func(sc *IPCVal) { sc.hwmPath = path }
+}
+
+// IPCValStateSync sets whether the high-water-mark file is fsync'd on every
+// save. Defaults to true; only relevant when IPCValStateFile is also set.
+func IPCValStateSync(sync bool) IPCValOption {
+	return func(sc *IPCVal) { sc.hwmSync = sync }
+}
+
+// IPCValEndpoints sets a list of backup remote signer addresses (e.g.
+// "unix:///var/run/hsm.sock" or "tcp://127.0.0.1:3000") that IPCVal keeps
+// hot standby connections to, in addition to the socketAddr passed to
+// NewIPCVal (which always stays in the pool and is tried first). If the
+// active signer's Ping fails or a sign call errors out, IPCVal transparently
+// fails over to the next healthy endpoint instead of bubbling the error up
+// to consensus. This lets operators run redundant signer processes (e.g. a
+// local HSM daemon plus a warm backup) without validator downtime during a
+// signer restart.
+func IPCValEndpoints(addrs []string) IPCValOption {
+	return func(sc *IPCVal) { sc.endpointAddrs = addrs }
+}
+
+// signerEndpoint is one candidate remote signer connection in IPCVal's pool.
+type signerEndpoint struct {
+	addr    string
+	conn    net.Conn
+	client  *RemoteSignerClient
+	healthy bool
+}
+
 // IPCVal implements PrivValidator.
 // It dials an external process and uses the unencrypted socket
 // to request signatures.
@@ -32,23 +72,34 @@ type IPCVal struct {
 	cmn.BaseService
 	*RemoteSignerClient
 
-	addr string
+	addr          string
+	endpointAddrs []string
 
 	connTimeout   time.Duration
 	connHeartbeat time.Duration
 
-	conn net.Conn
-	// connMtx guards writing and reading the field (methods on net.Conn itself are gorountine safe though)
+	endpoints []*signerEndpoint
+	primary   int
+	// connMtx guards endpoints, primary and the embedded RemoteSignerClient
 	connMtx sync.RWMutex
+	// signMtx serializes sign requests so a failover can never run
+	// concurrently with a request against the endpoint it is replacing.
+	signMtx sync.Mutex
 
 	cancelPing chan struct{}
 	pingTicker *time.Ticker
+
+	hwmPath string
+	hwmSync bool
+	hwm     *HighWaterMark
 }
 
 // Check that IPCVal implements PrivValidator.
 var _ types.PrivValidator = (*IPCVal)(nil)
 
-// NewIPCVal returns an instance of IPCVal.
+// NewIPCVal returns an instance of IPCVal dialing socketAddr. socketAddr is
+// always kept in the signer pool - and used as the primary signer by
+// default - even when IPCValEndpoints adds further backup addresses.
 func NewIPCVal(
 	logger log.Logger,
 	socketAddr string,
@@ -57,6 +108,7 @@ func NewIPCVal(
 		addr:          socketAddr,
 		connTimeout:   connTimeout,
 		connHeartbeat: connHeartbeat,
+		hwmSync:       true,
 	}
 
 	sc.BaseService = *cmn.NewBaseService(logger, "IPCVal", sc)
@@ -64,69 +116,99 @@ func NewIPCVal(
 	return sc
 }
 
+// addrs returns the full set of remote signer addresses IPCVal should
+// maintain connections to: socketAddr (always first, so it remains primary
+// by default) followed by any backups set via IPCValEndpoints, with
+// duplicates dropped.
+func (sc *IPCVal) addrs() []string {
+	if len(sc.endpointAddrs) == 0 {
+		return []string{sc.addr}
+	}
+
+	addrs := make([]string, 0, len(sc.endpointAddrs)+1)
+	seen := make(map[string]bool, len(sc.endpointAddrs)+1)
+	for _, addr := range append([]string{sc.addr}, sc.endpointAddrs...) {
+		if addr == "" || seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
 // OnStart implements cmn.Service.
 func (sc *IPCVal) OnStart() error {
-	err := sc.connect()
-	if err != nil {
+	if sc.hwmPath != "" {
+		hwm, err := NewHighWaterMark(sc.hwmPath, HighWaterMarkSync(sc.hwmSync))
+		if err != nil {
+			sc.Logger.Error("OnStart", "err", err)
+			return err
+		}
+		sc.hwm = hwm
+	}
+
+	sc.connMtx.Lock()
+	sc.endpoints = make([]*signerEndpoint, len(sc.addrs()))
+	for i, addr := range sc.addrs() {
+		sc.endpoints[i] = &signerEndpoint{addr: addr}
+	}
+	sc.connMtx.Unlock()
+
+	if err := sc.connectAll(); err != nil {
 		sc.Logger.Error("OnStart", "err", err)
 		return err
 	}
 
-	sc.connMtx.RLock()
-	defer sc.connMtx.RUnlock()
-	sc.RemoteSignerClient, err = NewRemoteSignerClient(sc.conn)
+	sc.connMtx.Lock()
+	err := sc.pickPrimaryLocked()
+	sc.connMtx.Unlock()
 	if err != nil {
 		return err
 	}
 
-	// Start a routine to keep the connection alive
+	// Start a routine that pings every endpoint in the pool - not just the
+	// primary - so a standby's connection dying is noticed before it is ever
+	// handed a live sign request, and fails over off of whichever endpoint
+	// stops responding.
 	sc.cancelPing = make(chan struct{}, 1)
 	sc.pingTicker = time.NewTicker(sc.connHeartbeat)
 	go func() {
 		for {
 			select {
 			case <-sc.pingTicker.C:
-				err := sc.Ping()
-				if err != nil {
-					sc.Logger.Error(
-						"Ping",
-						"err",
-						err,
-					)
-					if err == ErrUnexpectedResponse {
-						return
-					}
+				sc.connMtx.RLock()
+				endpoints := make([]*signerEndpoint, len(sc.endpoints))
+				copy(endpoints, sc.endpoints)
+				sc.connMtx.RUnlock()
 
-					err := sc.connect()
-					if err != nil {
-						sc.Logger.Error(
-							"Reconnecting to remote signer failed",
-							"err",
-							err,
-						)
-						continue
-					}
+				for _, ep := range endpoints {
 					sc.connMtx.RLock()
-					sc.RemoteSignerClient, err = NewRemoteSignerClient(sc.conn)
+					healthy, client := ep.healthy, ep.client
 					sc.connMtx.RUnlock()
-					if err != nil {
-						sc.Logger.Error(
-							"Re-initializing remote signer client failed",
-							"err",
-							err,
-						)
-						sc.connMtx.RLock()
-						if err := sc.conn.Close(); err != nil {
-							sc.Logger.Error(
-								"error closing connection",
-								"err",
-								err,
-							)
-						}
-						sc.connMtx.RUnlock()
+					if !healthy || client == nil {
 						continue
 					}
-					sc.Logger.Info("Re-created connection to remote signer", "impl", sc)
+
+					if err := client.Ping(); err != nil {
+						sc.Logger.Error("Ping", "addr", ep.addr, "err", err)
+						if err == ErrUnexpectedResponse {
+							return
+						}
+
+						sc.connMtx.Lock()
+						wasPrimary := sc.endpoints[sc.primary] == ep
+						if err := sc.markEndpointUnhealthyLocked(ep); err != nil {
+							sc.Logger.Error("Failing over to backup remote signer failed", "err", err)
+						} else if wasPrimary {
+							sc.Logger.Info("Failed over to backup remote signer", "addr", sc.endpoints[sc.primary].addr)
+						}
+						sc.connMtx.Unlock()
+					}
+				}
+
+				if err := sc.connectAll(); err != nil {
+					sc.Logger.Error("Reconnecting to remote signers failed", "err", err)
 				}
 			case <-sc.cancelPing:
 				sc.pingTicker.Stop()
@@ -145,27 +227,242 @@ func (sc *IPCVal) OnStop() {
 	}
 	sc.connMtx.RLock()
 	defer sc.connMtx.RUnlock()
-	if sc.conn != nil {
-		if err := sc.conn.Close(); err != nil {
-			sc.Logger.Error("OnStop", "err", err)
+	for _, ep := range sc.endpoints {
+		if ep.conn != nil {
+			if err := ep.conn.Close(); err != nil {
+				sc.Logger.Error("OnStop", "addr", ep.addr, "err", err)
+			}
 		}
 	}
 }
 
-func (sc *IPCVal) connect() error {
-	la, err := net.ResolveUnixAddr("unix", sc.addr)
+// connectAll (re)dials every endpoint that isn't currently marked healthy,
+// so standby signers stay warm between failovers. It only returns an error
+// if not a single endpoint, old or new, is healthy afterwards.
+func (sc *IPCVal) connectAll() error {
+	sc.connMtx.Lock()
+	defer sc.connMtx.Unlock()
+
+	var lastErr error
+	anyHealthy := false
+	for _, ep := range sc.endpoints {
+		if ep.healthy {
+			anyHealthy = true
+			continue
+		}
+		if err := sc.dialEndpointLocked(ep); err != nil {
+			sc.Logger.Error("Connecting to remote signer", "addr", ep.addr, "err", err)
+			lastErr = err
+			continue
+		}
+		anyHealthy = true
+	}
+
+	if !anyHealthy {
+		return lastErr
+	}
+	return nil
+}
+
+// dialEndpointLocked dials ep and wires up its RemoteSignerClient. Callers
+// must hold connMtx for writing.
+func (sc *IPCVal) dialEndpointLocked(ep *signerEndpoint) error {
+	network, address := parseEndpointAddr(ep.addr)
+
+	rawConn, err := net.DialTimeout(network, address, sc.connTimeout)
 	if err != nil {
 		return err
 	}
+	conn := newTimeoutConn(rawConn, sc.connTimeout)
 
-	conn, err := net.DialUnix("unix", nil, la)
+	client, err := NewRemoteSignerClient(conn)
 	if err != nil {
+		conn.Close()
 		return err
 	}
 
-	sc.connMtx.Lock()
-	defer sc.connMtx.Unlock()
-	sc.conn = newTimeoutConn(conn, sc.connTimeout)
-
+	ep.conn = conn
+	ep.client = client
+	ep.healthy = true
 	return nil
 }
+
+// pickPrimaryLocked selects the first healthy endpoint as primary. Callers
+// must hold connMtx for writing.
+func (sc *IPCVal) pickPrimaryLocked() error {
+	for i, ep := range sc.endpoints {
+		if ep.healthy {
+			sc.primary = i
+			sc.RemoteSignerClient = ep.client
+			return nil
+		}
+	}
+	return fmt.Errorf("privval: no healthy remote signer endpoints")
+}
+
+// markEndpointUnhealthyLocked closes ep's connection and marks it
+// unhealthy. If ep was the primary, the next healthy endpoint in the pool
+// (if any) is promoted in its place. Callers must hold connMtx for writing.
+func (sc *IPCVal) markEndpointUnhealthyLocked(ep *signerEndpoint) error {
+	ep.healthy = false
+	if ep.conn != nil {
+		ep.conn.Close()
+	}
+
+	if sc.endpoints[sc.primary] != ep {
+		return nil
+	}
+
+	for i, cand := range sc.endpoints {
+		if cand.healthy {
+			sc.primary = i
+			sc.RemoteSignerClient = cand.client
+			return nil
+		}
+	}
+
+	return fmt.Errorf("privval: no healthy remote signer endpoints remain")
+}
+
+// failoverLocked marks the current primary unhealthy and promotes the next
+// healthy endpoint, if any, to primary. Callers must hold connMtx for
+// writing.
+func (sc *IPCVal) failoverLocked() error {
+	return sc.markEndpointUnhealthyLocked(sc.endpoints[sc.primary])
+}
+
+// parseEndpointAddr splits an IPCValEndpoints entry into a dial network and
+// address, defaulting to unix (matching the raw socket paths NewIPCVal has
+// always accepted) when no scheme is given.
+func parseEndpointAddr(addr string) (network, address string) {
+	switch {
+	case strings.HasPrefix(addr, "tcp://"):
+		return "tcp", strings.TrimPrefix(addr, "tcp://")
+	case strings.HasPrefix(addr, "unix://"):
+		return "unix", strings.TrimPrefix(addr, "unix://")
+	default:
+		return "unix", addr
+	}
+}
+
+// signWithFailover serializes sign requests through whichever endpoint is
+// currently primary. If fn errors out - e.g. because the signer process
+// restarted or the connection died - it marks that endpoint unhealthy, fails
+// over to the next healthy one and retries, walking the rest of the pool
+// (each endpoint tried at most once) until fn succeeds or every endpoint has
+// failed, so a signer restart never surfaces to consensus as long as one
+// other endpoint in the pool is healthy.
+func (sc *IPCVal) signWithFailover(fn func(*RemoteSignerClient) error) error {
+	sc.signMtx.Lock()
+	defer sc.signMtx.Unlock()
+
+	sc.connMtx.RLock()
+	client := sc.RemoteSignerClient
+	numEndpoints := len(sc.endpoints)
+	sc.connMtx.RUnlock()
+
+	err := fn(client)
+	if err == nil {
+		return nil
+	}
+
+	for attempt := 0; attempt < numEndpoints-1; attempt++ {
+		sc.Logger.Error("Sign request failed, failing over to backup remote signer", "err", err)
+
+		sc.connMtx.Lock()
+		failErr := sc.failoverLocked()
+		if failErr == nil {
+			client = sc.RemoteSignerClient
+		}
+		sc.connMtx.Unlock()
+
+		if failErr != nil {
+			sc.Logger.Error("Failover has no healthy remote signer to retry against", "err", failErr)
+			return err
+		}
+
+		err = fn(client)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// GetPubKey implements types.PrivValidator, overriding the embedded
+// RemoteSignerClient so the read goes through connMtx -
+// markEndpointUnhealthyLocked reassigns that field on every failover, whether
+// triggered by the heartbeat or by a failed sign request, not just once at
+// startup, so reading it without the lock would race.
+func (sc *IPCVal) GetPubKey() crypto.PubKey {
+	sc.connMtx.RLock()
+	client := sc.RemoteSignerClient
+	sc.connMtx.RUnlock()
+
+	return client.GetPubKey()
+}
+
+// SignVote implements types.PrivValidator, enforcing the high-water-mark
+// (if configured) before dispatching to whichever remote signer is primary.
+func (sc *IPCVal) SignVote(chainID string, vote *types.Vote) error {
+	signRemote := func(c *RemoteSignerClient) error { return c.SignVote(chainID, vote) }
+
+	if sc.hwm == nil {
+		return sc.signWithFailover(signRemote)
+	}
+
+	signBytes := vote.SignBytes(chainID)
+	st := stepForVoteType(vote.Type)
+
+	if sig, err := sc.hwm.CheckHRS(vote.Height, vote.Round, st, signBytes); err != nil {
+		return err
+	} else if sig != nil {
+		vote.Signature = sig
+		return nil
+	}
+
+	if err := sc.signWithFailover(signRemote); err != nil {
+		return err
+	}
+
+	return sc.hwm.Advance(vote.Height, vote.Round, st, signBytes, vote.Signature)
+}
+
+// SignProposal implements types.PrivValidator, enforcing the high-water-mark
+// (if configured) before dispatching to whichever remote signer is primary.
+func (sc *IPCVal) SignProposal(chainID string, proposal *types.Proposal) error {
+	signRemote := func(c *RemoteSignerClient) error { return c.SignProposal(chainID, proposal) }
+
+	if sc.hwm == nil {
+		return sc.signWithFailover(signRemote)
+	}
+
+	signBytes := proposal.SignBytes(chainID)
+
+	if sig, err := sc.hwm.CheckHRS(proposal.Height, proposal.Round, stepPropose, signBytes); err != nil {
+		return err
+	} else if sig != nil {
+		proposal.Signature = sig
+		return nil
+	}
+
+	if err := sc.signWithFailover(signRemote); err != nil {
+		return err
+	}
+
+	return sc.hwm.Advance(proposal.Height, proposal.Round, stepPropose, signBytes, proposal.Signature)
+}
+
+// stepForVoteType maps a vote's type to the step used by the high-water-mark,
+// matching the propose/prevote/precommit ordering consensus itself relies on.
+func stepForVoteType(voteType types.SignedMsgType) step {
+	switch voteType {
+	case types.PrevoteType:
+		return stepPrevote
+	case types.PrecommitType:
+		return stepPrecommit
+	default:
+		return stepPropose
+	}
+}
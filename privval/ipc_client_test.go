@@ -0,0 +1,45 @@
+package privval
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+func TestParseEndpointAddr(t *testing.T) {
+	testCases := []struct {
+		addr        string
+		wantNetwork string
+		wantAddress string
+	}{
+		{"/var/run/signer.sock", "unix", "/var/run/signer.sock"},
+		{"unix:///var/run/signer.sock", "unix", "/var/run/signer.sock"},
+		{"tcp://127.0.0.1:3000", "tcp", "127.0.0.1:3000"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.addr, func(t *testing.T) {
+			network, address := parseEndpointAddr(tc.addr)
+			require.Equal(t, tc.wantNetwork, network)
+			require.Equal(t, tc.wantAddress, address)
+		})
+	}
+}
+
+func TestIPCValAddrsDefaultsToSocketAddr(t *testing.T) {
+	sc := NewIPCVal(log.TestingLogger(), "/var/run/primary.sock")
+	require.Equal(t, []string{"/var/run/primary.sock"}, sc.addrs())
+}
+
+func TestIPCValAddrsKeepsSocketAddrFirstAndDedups(t *testing.T) {
+	sc := NewIPCVal(log.TestingLogger(), "/var/run/primary.sock")
+	IPCValEndpoints([]string{"/var/run/backup.sock", "/var/run/primary.sock", "tcp://127.0.0.1:3000"})(sc)
+
+	require.Equal(t, []string{
+		"/var/run/primary.sock",
+		"/var/run/backup.sock",
+		"tcp://127.0.0.1:3000",
+	}, sc.addrs())
+}
@@ -0,0 +1,269 @@
+package privval
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+
+	"github.com/tendermint/tendermint/crypto"
+	cmn "github.com/tendermint/tendermint/libs/common"
+	"github.com/tendermint/tendermint/libs/log"
+	"github.com/tendermint/tendermint/types"
+)
+
+// PKCS11SignerOption sets an optional parameter on the PKCS11Signer.
+type PKCS11SignerOption func(*PKCS11Signer)
+
+// PKCS11SignerSlot sets the token slot to open a session against.
+// Defaults to slot 0.
+func PKCS11SignerSlot(slot uint) PKCS11SignerOption {
+	return func(s *PKCS11Signer) { s.slot = slot }
+}
+
+// PKCS11SignerPIN sets the user PIN used to log in to the token before
+// signing.
+func PKCS11SignerPIN(pin string) PKCS11SignerOption {
+	return func(s *PKCS11Signer) { s.pin = pin }
+}
+
+// PKCS11Signer implements types.PrivValidator by delegating every signing
+// operation to an ed25519 key held inside a PKCS#11 token (e.g. SoftHSM,
+// YubiHSM, AWS CloudHSM). The module path, slot and key label are only used
+// to locate the key inside the token; the raw private key material never
+// enters process memory.
+//
+// PKCS11Signer is meant to be driven behind a socket listener (see
+// ServePKCS11Signer) so that IPCVal can reach it over the same
+// RemoteSignerClient/RemoteSignerMsg protocol it already speaks to any other
+// external signing process.
+type PKCS11Signer struct {
+	cmn.BaseService
+
+	modulePath string
+	slot       uint
+	pin        string
+	keyLabel   string
+
+	mtx     sync.Mutex
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	keyObj  pkcs11.ObjectHandle
+
+	pubKey crypto.PubKey
+}
+
+// Check that PKCS11Signer implements PrivValidator.
+var _ types.PrivValidator = (*PKCS11Signer)(nil)
+
+// NewPKCS11Signer returns a PKCS11Signer that signs through the PKCS#11
+// module at modulePath, using the key labelled keyLabel.
+func NewPKCS11Signer(
+	logger log.Logger,
+	modulePath string,
+	keyLabel string,
+	options ...PKCS11SignerOption,
+) *PKCS11Signer {
+	s := &PKCS11Signer{
+		modulePath: modulePath,
+		keyLabel:   keyLabel,
+	}
+
+	for _, optionFunc := range options {
+		optionFunc(s)
+	}
+
+	s.BaseService = *cmn.NewBaseService(logger, "PKCS11Signer", s)
+
+	return s
+}
+
+// OnStart implements cmn.Service. It opens the PKCS#11 module, logs in to
+// the configured slot and resolves the signing key and its public
+// counterpart.
+func (s *PKCS11Signer) OnStart() error {
+	ctx := pkcs11.New(s.modulePath)
+	if ctx == nil {
+		return fmt.Errorf("privval: unable to load PKCS#11 module %q", s.modulePath)
+	}
+	if err := ctx.Initialize(); err != nil {
+		return fmt.Errorf("privval: initializing PKCS#11 module: %w", err)
+	}
+
+	session, err := ctx.OpenSession(s.slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		ctx.Destroy()
+		return fmt.Errorf("privval: opening PKCS#11 session on slot %d: %w", s.slot, err)
+	}
+	if err := ctx.Login(session, pkcs11.CKU_USER, s.pin); err != nil {
+		ctx.CloseSession(session)
+		ctx.Destroy()
+		return fmt.Errorf("privval: logging in to PKCS#11 slot %d: %w", s.slot, err)
+	}
+
+	keyObj, pubKey, err := findEd25519Key(ctx, session, s.keyLabel)
+	if err != nil {
+		ctx.Logout(session)
+		ctx.CloseSession(session)
+		ctx.Destroy()
+		return fmt.Errorf("privval: locating key %q: %w", s.keyLabel, err)
+	}
+
+	s.mtx.Lock()
+	s.ctx = ctx
+	s.session = session
+	s.keyObj = keyObj
+	s.pubKey = pubKey
+	s.mtx.Unlock()
+
+	return nil
+}
+
+// OnStop implements cmn.Service.
+func (s *PKCS11Signer) OnStop() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.ctx == nil {
+		return
+	}
+	if err := s.ctx.Logout(s.session); err != nil {
+		s.Logger.Error("OnStop: logout", "err", err)
+	}
+	if err := s.ctx.CloseSession(s.session); err != nil {
+		s.Logger.Error("OnStop: close session", "err", err)
+	}
+	s.ctx.Destroy()
+	s.ctx.Finalize()
+	s.ctx = nil
+}
+
+// GetPubKey implements types.PrivValidator.
+func (s *PKCS11Signer) GetPubKey() crypto.PubKey {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.pubKey
+}
+
+// SignVote implements types.PrivValidator, signing the vote's canonical
+// sign bytes with the HSM-resident key.
+func (s *PKCS11Signer) SignVote(chainID string, vote *types.Vote) error {
+	sig, err := s.sign(vote.SignBytes(chainID))
+	if err != nil {
+		return err
+	}
+	vote.Signature = sig
+	return nil
+}
+
+// SignProposal implements types.PrivValidator, signing the proposal's
+// canonical sign bytes with the HSM-resident key.
+func (s *PKCS11Signer) SignProposal(chainID string, proposal *types.Proposal) error {
+	sig, err := s.sign(proposal.SignBytes(chainID))
+	if err != nil {
+		return err
+	}
+	proposal.Signature = sig
+	return nil
+}
+
+func (s *PKCS11Signer) sign(signBytes []byte) ([]byte, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.ctx == nil {
+		return nil, fmt.Errorf("privval: PKCS11Signer is not started")
+	}
+
+	if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EDDSA, nil)}, s.keyObj); err != nil {
+		return nil, fmt.Errorf("privval: SignInit: %w", err)
+	}
+	sig, err := s.ctx.Sign(s.session, signBytes)
+	if err != nil {
+		return nil, fmt.Errorf("privval: Sign: %w", err)
+	}
+	return sig, nil
+}
+
+// findEd25519Key looks up the ed25519 private key object labelled label in
+// the given session, and reconstructs the matching crypto.PubKey from its
+// PKCS#11 public key counterpart.
+func findEd25519Key(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, label string) (pkcs11.ObjectHandle, crypto.PubKey, error) {
+	keyObj, err := findObjectByClassAndLabel(ctx, session, pkcs11.CKO_PRIVATE_KEY, label)
+	if err != nil {
+		return 0, nil, fmt.Errorf("no private key found with label %q: %w", label, err)
+	}
+
+	pubObj, err := findObjectByClassAndLabel(ctx, session, pkcs11.CKO_PUBLIC_KEY, label)
+	if err != nil {
+		return 0, nil, fmt.Errorf("no public key found with label %q: %w", label, err)
+	}
+
+	attrs, err := ctx.GetAttributeValue(session, pubObj, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	point, err := ed25519PointFromCKAECPoint(attrs[0].Value)
+	if err != nil {
+		return 0, nil, fmt.Errorf("decoding public key for %q: %w", label, err)
+	}
+	if len(point) != ed25519PubKeySize {
+		return 0, nil, fmt.Errorf(
+			"unexpected ed25519 public key length for %q: got %d bytes, want %d",
+			label, len(point), ed25519PubKeySize,
+		)
+	}
+
+	var pubKey crypto.PubKeyEd25519
+	copy(pubKey[:], point)
+
+	return keyObj, pubKey, nil
+}
+
+// findObjectByClassAndLabel runs a single PKCS#11 object search to
+// completion - Init, Find, then Final - before returning, since a token
+// only permits one active search per session at a time; starting a second
+// search while the first's FindObjectsFinal is still only deferred would
+// fail with CKR_OPERATION_ACTIVE on SoftHSM and most compliant tokens.
+func findObjectByClassAndLabel(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, class uint, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return 0, err
+	}
+
+	objs, _, err := ctx.FindObjects(session, 1)
+	if finalErr := ctx.FindObjectsFinal(session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(objs) == 0 {
+		return 0, fmt.Errorf("no object found")
+	}
+
+	return objs[0], nil
+}
+
+// ed25519PubKeySize is the length, in bytes, of a raw ed25519 public key.
+const ed25519PubKeySize = 32
+
+// ed25519PointFromCKAECPoint unwraps the DER OCTET STRING encoding PKCS#11
+// tokens report CKA_EC_POINT in (e.g. 04 20 <32 raw bytes> for a 32-byte
+// point), returning the raw point bytes. Copying attrs[0].Value directly
+// into a fixed-size key would silently shift it by the DER header instead
+// of failing.
+func ed25519PointFromCKAECPoint(raw []byte) ([]byte, error) {
+	var point []byte
+	if _, err := asn1.Unmarshal(raw, &point); err != nil {
+		return nil, err
+	}
+	return point, nil
+}
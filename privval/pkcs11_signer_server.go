@@ -0,0 +1,137 @@
+package privval
+
+import (
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+// maxRemoteSignerMsgSize bounds how large a single RemoteSignerMsg the
+// server will read off the wire, mirroring the limit RemoteSignerClient
+// enforces when it reads responses.
+const maxRemoteSignerMsgSize = 1024 * 10
+
+// ServePKCS11Signer listens on socketAddr (a unix socket path) and serves
+// RemoteSignerMsg requests by delegating every SignVoteRequest and
+// SignProposalRequest to signer. It speaks the same wire protocol as
+// RemoteSignerClient, so a validator can point IPCVal at socketAddr exactly
+// as it would for any other external signing process - the PKCS#11 backing
+// is transparent to the consensus engine.
+//
+// ServePKCS11Signer blocks serving connections until the listener is closed
+// or closeCh is closed.
+func ServePKCS11Signer(logger log.Logger, socketAddr string, signer *PKCS11Signer, closeCh <-chan struct{}) error {
+	if err := signer.Start(); err != nil {
+		return fmt.Errorf("privval: starting PKCS11Signer: %w", err)
+	}
+	defer signer.Stop()
+
+	ln, err := net.Listen("unix", socketAddr)
+	if err != nil {
+		return fmt.Errorf("privval: listening on %q: %w", socketAddr, err)
+	}
+	defer ln.Close()
+
+	go func() {
+		<-closeCh
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-closeCh:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		go func() {
+			defer conn.Close()
+			if err := handleRemoteSignerConn(conn, signer); err != nil {
+				logger.Error("ServePKCS11Signer: connection handler exited", "err", err)
+			}
+		}()
+	}
+}
+
+// handleRemoteSignerConn reads RemoteSignerMsg requests off conn and writes
+// back the signer's responses, one request at a time, until the connection
+// is closed or a protocol error occurs.
+func handleRemoteSignerConn(conn net.Conn, signer *PKCS11Signer) error {
+	for {
+		req, err := readRemoteSignerMsg(conn)
+		if err != nil {
+			return err
+		}
+
+		res, err := handleRemoteSignerMsg(signer, req)
+		if err != nil {
+			return err
+		}
+
+		if err := writeRemoteSignerMsg(conn, res); err != nil {
+			return err
+		}
+	}
+}
+
+// readRemoteSignerMsg decodes a single length-prefixed RemoteSignerMsg off
+// r, using the same amino codec (cdc) and wire type RemoteSignerClient
+// already encodes its requests with.
+func readRemoteSignerMsg(r io.Reader) (RemoteSignerMsg, error) {
+	var msg RemoteSignerMsg
+	_, err := cdc.UnmarshalBinaryLengthPrefixedReader(r, &msg, maxRemoteSignerMsgSize)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeRemoteSignerMsg encodes msg using the same length-prefixed amino
+// encoding RemoteSignerClient expects to read on the other end of the
+// socket.
+func writeRemoteSignerMsg(w io.Writer, msg RemoteSignerMsg) error {
+	_, err := cdc.MarshalBinaryLengthPrefixedWriter(w, msg)
+	return err
+}
+
+// handleRemoteSignerMsg dispatches a single decoded RemoteSignerMsg request
+// to signer and returns the RemoteSignerMsg response to write back. Signing
+// errors are returned embedded in the response (as RemoteSignerClient
+// expects), not as a Go error - a Go error here means the connection itself
+// is no longer usable.
+func handleRemoteSignerMsg(signer *PKCS11Signer, req RemoteSignerMsg) (RemoteSignerMsg, error) {
+	switch r := req.(type) {
+	case *PubKeyRequest:
+		return &PubKeyResponse{PubKey: signer.GetPubKey()}, nil
+
+	case *SignVoteRequest:
+		if err := signer.SignVote(r.ChainID, r.Vote); err != nil {
+			return &SignedVoteResponse{Error: remoteSignerError(err)}, nil
+		}
+		return &SignedVoteResponse{Vote: r.Vote}, nil
+
+	case *SignProposalRequest:
+		if err := signer.SignProposal(r.ChainID, r.Proposal); err != nil {
+			return &SignedProposalResponse{Error: remoteSignerError(err)}, nil
+		}
+		return &SignedProposalResponse{Proposal: r.Proposal}, nil
+
+	case *PingRequest:
+		return &PingResponse{}, nil
+
+	default:
+		return nil, fmt.Errorf("privval: unknown RemoteSignerMsg %T", req)
+	}
+}
+
+// remoteSignerError wraps a signing error in the RemoteSignerError type
+// RemoteSignerClient already knows how to surface to its caller.
+func remoteSignerError(err error) *RemoteSignerError {
+	return &RemoteSignerError{Description: err.Error()}
+}